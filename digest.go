@@ -0,0 +1,76 @@
+// Copyright 2025 by Harald Albrecht
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gtar
+
+import (
+	"fmt"
+
+	"github.com/thediveo/gtar/digest"
+)
+
+// Digest returns the content digest of the named tar entry, computed by
+// [digest.FileDigest] from its canonicalized header and payload, streamed
+// through [Index.Open]. The digest is computed on first use and then cached
+// for the lifetime of this Index.
+func (i *Index) Digest(name string) (digest.Digest, error) {
+	el, ok := i.tree.Get([]byte(i.fullKey(name)))
+	if !ok {
+		return "", fmt.Errorf("tar file %q: no such element %q", i.f.Name(), name)
+	}
+
+	i.digestsMu.Lock()
+	if d, ok := i.digests[name]; ok {
+		i.digestsMu.Unlock()
+		return d, nil
+	}
+	i.digestsMu.Unlock()
+
+	r, err := i.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	d, err := digest.FileDigest(el.Header, r)
+	if err != nil {
+		return "", err
+	}
+
+	i.digestsMu.Lock()
+	if i.digests == nil {
+		i.digests = map[string]digest.Digest{}
+	}
+	i.digests[name] = d
+	i.digestsMu.Unlock()
+	return d, nil
+}
+
+// ArchiveDigest returns the content digest of the whole Index, computed by
+// [digest.Archive] from every entry's own [Index.Digest]. The digest is
+// computed on first use and then cached for the lifetime of this Index.
+func (i *Index) ArchiveDigest() (digest.Digest, error) {
+	i.archiveDigestOnce.Do(func() {
+		var entries []digest.PathDigest
+		for name := range i.All() {
+			d, err := i.Digest(name)
+			if err != nil {
+				i.archiveDigestErr = err
+				return
+			}
+			entries = append(entries, digest.PathDigest{Path: name, Digest: d})
+		}
+		i.archiveDigest = digest.Archive(entries)
+	})
+	return i.archiveDigest, i.archiveDigestErr
+}
@@ -0,0 +1,125 @@
+// Copyright 2025 by Harald Albrecht
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package digest computes deterministic, content-addressable digests for
+// individual tar entries and whole tarball indices, following the approach
+// popularized by Docker's tarsum and BuildKit's contenthash: a per-entry
+// digest hashes a canonicalized rendering of the entry's stable header
+// fields followed by its raw payload, and a whole-archive digest hashes the
+// sorted-by-path list of the entries' own digests, so that neither volatile
+// header fields (mtime/atime/ctime, tar format padding) nor the order
+// entries happen to appear in the original tar stream affect the result.
+package digest
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Digest is a content digest, formatted as "<algorithm>:<hex-encoded
+// hash>", e.g. "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855".
+type Digest string
+
+// String returns d's string representation.
+func (d Digest) String() string { return string(d) }
+
+// PathDigest pairs a tar entry's path with its content [Digest], as used by
+// [Archive] to compute a whole-archive digest.
+type PathDigest struct {
+	Path   string
+	Digest Digest
+}
+
+// FileDigest computes the content digest of a single tar entry: a
+// canonicalized rendering of hdr's stable fields (see [writeCanonicalHeader]
+// for the exact field list and order), followed by the entry's raw payload
+// read from content. Two tar entries with the same canonicalized header and
+// payload always produce the same digest, regardless of their tar format or
+// volatile fields such as ModTime.
+func FileDigest(hdr *tar.Header, content io.Reader) (Digest, error) {
+	h := sha256.New()
+	writeCanonicalHeader(h, hdr)
+	if _, err := io.Copy(h, content); err != nil {
+		return "", fmt.Errorf("cannot digest tar entry %q: %w", hdr.Name, err)
+	}
+	return sha256Digest(h), nil
+}
+
+// writeCanonicalHeader renders hdr's stable fields into h as a fixed,
+// ordered list of "key=value\n" pairs: name, mode, uid, gid, size,
+// typeflag, linkname, devmajor, devminor, followed by any "SCHILY.xattr."
+// PAX records (sorted by key). Volatile fields such as
+// ModTime/AccessTime/ChangeTime, as well as tar format and block padding,
+// are deliberately excluded, so that otherwise identical content produces
+// the same digest independent of how or when it was archived.
+func writeCanonicalHeader(h io.Writer, hdr *tar.Header) {
+	field := func(key, value string) {
+		io.WriteString(h, key)
+		io.WriteString(h, "=")
+		io.WriteString(h, value)
+		io.WriteString(h, "\n")
+	}
+	field("name", hdr.Name)
+	field("mode", strconv.FormatInt(hdr.Mode, 8))
+	field("uid", strconv.Itoa(hdr.Uid))
+	field("gid", strconv.Itoa(hdr.Gid))
+	field("size", strconv.FormatInt(hdr.Size, 10))
+	field("typeflag", string(hdr.Typeflag))
+	field("linkname", hdr.Linkname)
+	field("devmajor", strconv.FormatInt(hdr.Devmajor, 10))
+	field("devminor", strconv.FormatInt(hdr.Devminor, 10))
+
+	const xattrPrefix = "SCHILY.xattr."
+	xattrKeys := make([]string, 0, len(hdr.PAXRecords))
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, xattrPrefix) {
+			xattrKeys = append(xattrKeys, key)
+		}
+	}
+	sort.Strings(xattrKeys)
+	for _, key := range xattrKeys {
+		field("xattr."+strings.TrimPrefix(key, xattrPrefix), hdr.PAXRecords[key])
+	}
+}
+
+// Archive computes a deterministic whole-archive digest from entries' own
+// digests: a SHA-256 over "path=digest\n" lines, sorted by Path, so that
+// the archive digest does not depend on the order entries happened to
+// appear in the original tar stream.
+func Archive(entries []PathDigest) Digest {
+	sorted := make([]PathDigest, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+
+	h := sha256.New()
+	for _, entry := range sorted {
+		io.WriteString(h, entry.Path)
+		io.WriteString(h, "=")
+		io.WriteString(h, string(entry.Digest))
+		io.WriteString(h, "\n")
+	}
+	return sha256Digest(h)
+}
+
+// sha256Digest renders h's current sum as a [Digest].
+func sha256Digest(h hash.Hash) Digest {
+	return Digest("sha256:" + hex.EncodeToString(h.Sum(nil)))
+}
@@ -0,0 +1,93 @@
+// Copyright 2025 by Harald Albrecht
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package digest
+
+import (
+	"archive/tar"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/success"
+)
+
+var _ = Describe("content digests", func() {
+
+	baseHeader := func() *tar.Header {
+		return &tar.Header{
+			Name:     "foo",
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			Size:     3,
+			ModTime:  time.Unix(12345, 0),
+		}
+	}
+
+	It("produces the same digest regardless of ModTime", func() {
+		h1 := baseHeader()
+		h2 := baseHeader()
+		h2.ModTime = time.Unix(0, 0)
+
+		d1 := Successful(FileDigest(h1, strings.NewReader("foo")))
+		d2 := Successful(FileDigest(h2, strings.NewReader("foo")))
+		Expect(d1).To(Equal(d2))
+	})
+
+	It("produces different digests for different content", func() {
+		d1 := Successful(FileDigest(baseHeader(), strings.NewReader("foo")))
+		d2 := Successful(FileDigest(baseHeader(), strings.NewReader("bar")))
+		Expect(d1).NotTo(Equal(d2))
+	})
+
+	It("produces different digests for different names", func() {
+		h1 := baseHeader()
+		h2 := baseHeader()
+		h2.Name = "bar"
+
+		d1 := Successful(FileDigest(h1, strings.NewReader("foo")))
+		d2 := Successful(FileDigest(h2, strings.NewReader("foo")))
+		Expect(d1).NotTo(Equal(d2))
+	})
+
+	It("takes xattrs into account", func() {
+		h1 := baseHeader()
+		h2 := baseHeader()
+		h2.PAXRecords = map[string]string{"SCHILY.xattr.user.foo": "bar"}
+
+		d1 := Successful(FileDigest(h1, strings.NewReader("foo")))
+		d2 := Successful(FileDigest(h2, strings.NewReader("foo")))
+		Expect(d1).NotTo(Equal(d2))
+	})
+
+	It("computes an archive digest independent of entry order", func() {
+		entries1 := []PathDigest{
+			{Path: "a", Digest: "sha256:1111"},
+			{Path: "b", Digest: "sha256:2222"},
+		}
+		entries2 := []PathDigest{
+			{Path: "b", Digest: "sha256:2222"},
+			{Path: "a", Digest: "sha256:1111"},
+		}
+		Expect(Archive(entries1)).To(Equal(Archive(entries2)))
+	})
+
+	It("computes a different archive digest when a per-entry digest changes", func() {
+		entries1 := []PathDigest{{Path: "a", Digest: "sha256:1111"}}
+		entries2 := []PathDigest{{Path: "a", Digest: "sha256:9999"}}
+		Expect(Archive(entries1)).NotTo(Equal(Archive(entries2)))
+	})
+
+})
@@ -0,0 +1,141 @@
+// Copyright 2025 by Harald Albrecht
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gtar
+
+import (
+	"archive/tar"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/fdooze"
+	. "github.com/thediveo/success"
+)
+
+// writeDigestTestFile assembles a tar archive from name/content pairs,
+// written in the given order and with modtime/atime/ctime offset by the
+// pair's index, so that tests can check that these volatile header fields
+// and the entries' order don't affect the resulting digests.
+func writeDigestTestFile(order []string, files map[string][]byte) string {
+	tf := Successful(os.CreateTemp("", "testing-digest-*"))
+	defer tf.Close()
+	tw := tar.NewWriter(tf)
+	for idx, name := range order {
+		content := files[name]
+		t := time.Unix(int64(1000+idx), 0)
+		Expect(tw.WriteHeader(&tar.Header{
+			Name:       name,
+			Format:     tar.FormatPAX, // otherwise tar.Writer drops atime/ctime.
+			Typeflag:   tar.TypeReg,
+			Size:       int64(len(content)),
+			Mode:       0o644,
+			ModTime:    t,
+			AccessTime: t,
+			ChangeTime: t,
+		})).To(Succeed())
+		Expect(tw.Write(content)).Error().NotTo(HaveOccurred())
+	}
+	Expect(tw.Close()).To(Succeed())
+	return tf.Name()
+}
+
+var _ = Describe("content digests", Ordered, func() {
+
+	var tarballPath string
+
+	BeforeAll(func() {
+		tarballPath = writeFSTestFile(map[string][]byte{
+			"foo":     []byte("foo"),
+			"bar/baz": []byte("1234567890"),
+		}, nil)
+		DeferCleanup(func() {
+			Expect(os.Remove(tarballPath)).To(Succeed())
+		})
+	})
+
+	BeforeEach(func() {
+		goodfds := Filedescriptors()
+		DeferCleanup(func() {
+			Eventually(Filedescriptors).Within(2 * time.Second).ProbeEvery(100 * time.Millisecond).
+				ShouldNot(HaveLeakedFds(goodfds))
+		})
+	})
+
+	It("fails to digest an unknown entry", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		Expect(i.Digest("nada-nothing-nix")).Error().To(HaveOccurred())
+	})
+
+	It("computes and caches a per-entry digest", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+
+		d1 := Successful(i.Digest("foo"))
+		d2 := Successful(i.Digest("foo"))
+		Expect(d1).To(Equal(d2))
+		Expect(i.Digest("bar/baz")).NotTo(Equal(d1))
+	})
+
+	It("computes a stable archive digest", func() {
+		i1 := Successful(New(tarballPath))
+		defer i1.Close()
+		i2 := Successful(New(tarballPath))
+		defer i2.Close()
+
+		Expect(i1.ArchiveDigest()).To(Equal(Successful(i2.ArchiveDigest())))
+	})
+
+	It("ignores volatile header fields in a per-entry digest", func() {
+		content := map[string][]byte{
+			"foo": []byte("foo"),
+			"pad": []byte("pad"),
+		}
+		path1 := writeDigestTestFile([]string{"foo"}, content)
+		defer os.Remove(path1)
+		path2 := writeDigestTestFile([]string{"pad", "foo"}, content)
+		defer os.Remove(path2)
+
+		i1 := Successful(New(path1))
+		defer i1.Close()
+		i2 := Successful(New(path2))
+		defer i2.Close()
+
+		// Both tarballs carry the same "foo" entry, but at different indices and
+		// thus with different mtime/atime/ctime (see writeDigestTestFile), yet
+		// the per-entry digest must agree.
+		Expect(i1.Digest("foo")).To(Equal(Successful(i2.Digest("foo"))))
+	})
+
+	It("ignores entry order in the archive digest", func() {
+		content := map[string][]byte{
+			"foo":     []byte("foo"),
+			"bar/baz": []byte("1234567890"),
+		}
+		forwardPath := writeDigestTestFile([]string{"foo", "bar/baz"}, content)
+		defer os.Remove(forwardPath)
+		reversePath := writeDigestTestFile([]string{"bar/baz", "foo"}, content)
+		defer os.Remove(reversePath)
+
+		forward := Successful(New(forwardPath))
+		defer forward.Close()
+		reverse := Successful(New(reversePath))
+		defer reverse.Close()
+
+		Expect(forward.ArchiveDigest()).To(Equal(Successful(reverse.ArchiveDigest())))
+	})
+
+})
@@ -0,0 +1,366 @@
+// Copyright 2025 by Harald Albrecht
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gtar
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	iradix "github.com/hashicorp/go-immutable-radix/v2"
+	"golang.org/x/sys/unix"
+)
+
+// eStargzTOCEntryName is the name under which the eStargz/stargz table of
+// contents is stored as the last regular entry of the archive, immediately
+// before the footer.
+const eStargzTOCEntryName = "stargz.index.json"
+
+// eStargzFooterSize is the size in bytes of the fixed-size footer appended
+// at the very end of an eStargz/stargz file. It carries nothing but the
+// byte offset of the TOC entry's gzip member within the file, encoded as an
+// unsigned 64bit big endian integer.
+const eStargzFooterSize = 8
+
+// estargzTOC is the JSON document describing the entries of an eStargz/stargz
+// archive, as referenced by the footer.
+type estargzTOC struct {
+	Version int               `json:"version"`
+	Entries []estargzTOCEntry `json:"entries"`
+}
+
+// estargzTOCEntry describes a single entry of an eStargz/stargz archive: its
+// usual tar metadata, plus the byte offset of its gzip member within the
+// (compressed) archive file and, for large files split into independently
+// decompressable chunks, the logical offset and size of this particular
+// chunk.
+//
+// A file stored as a single gzip member has exactly one TOC entry for its
+// Name, with ChunkOffset and ChunkSize left at zero; Size carries the file's
+// full logical size, as with a non-chunked archive. A file split into
+// several independently decompressable chunks instead has one TOC entry per
+// chunk, all sharing the same Name: ChunkOffset is that chunk's logical
+// start within the file and ChunkSize its logical length, the chunks
+// together covering [0, total size) with no gaps and in no particular
+// order.
+//
+// Type mirrors the real containerd/stargz-snapshotter TOC format, which
+// spells out the entry kind as one of "reg", "dir", "symlink", "hardlink",
+// "char", "block", "fifo", or "chunk" (a continuation entry of an already
+// registered multi-chunk file), rather than a raw tar type-flag byte; see
+// [estargzTypeflag] for the mapping onto [tar.Header.Typeflag].
+type estargzTOCEntry struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modtime,omitempty"`
+	Mode        int64     `json:"mode"`
+	Linkname    string    `json:"linkname,omitempty"`
+	Offset      int64     `json:"offset"`
+	ChunkOffset int64     `json:"chunkOffset,omitempty"`
+	ChunkSize   int64     `json:"chunkSize,omitempty"`
+}
+
+// estargzTypeflag maps an eStargz/stargz TOC entry's Type string onto the
+// corresponding [tar.Header] type flag byte; a "chunk" continuation entry
+// maps onto tar.TypeReg, as it merely carries another chunk of an
+// already-registered regular file's content.
+func estargzTypeflag(t string) (byte, error) {
+	switch t {
+	case "reg", "chunk":
+		return tar.TypeReg, nil
+	case "dir":
+		return tar.TypeDir, nil
+	case "symlink":
+		return tar.TypeSymlink, nil
+	case "hardlink":
+		return tar.TypeLink, nil
+	case "char":
+		return tar.TypeChar, nil
+	case "block":
+		return tar.TypeBlock, nil
+	case "fifo":
+		return tar.TypeFifo, nil
+	default:
+		return 0, fmt.Errorf("unknown eStargz TOC entry type %q", t)
+	}
+}
+
+// estargzChunk locates one chunk of a (possibly multi-chunk) eStargz entry's
+// content: the byte offset of its gzip member within the archive file, and
+// the chunk's logical length.
+type estargzChunk struct {
+	MemberOffset int64
+	Size         int64
+}
+
+// NewFromEStargz returns a new TAR file Index object for the specified
+// os.File, assuming it is laid out as an eStargz/stargz archive: a sequence
+// of independently gzip-compressed tar entries, with the table of contents
+// (TOC) stored as the last entry and a small fixed-size footer at the very
+// end of the file pointing at the TOC's gzip member.
+//
+// Unlike [NewFromFile], which has to scan the whole archive in order to
+// build its Index, NewFromEStargz only ever reads the footer and the TOC,
+// turning index creation from O(archive) into O(TOC). Opening an entry
+// later seeks straight to that entry's gzip member instead of re-scanning
+// the tar stream from the beginning.
+//
+// As with [NewFromFile], the caller remains responsible for closing tarf;
+// the returned Index duplicates the file descriptor it needs.
+func NewFromEStargz(tarf *os.File) (*Index, error) {
+	newfd, err := unix.Dup(int(tarf.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	index := &Index{
+		f:          os.NewFile(uintptr(newfd), tarf.Name()),
+		compressed: true,
+	}
+
+	stat, err := index.f.Stat()
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+	if stat.Size() < eStargzFooterSize {
+		index.Close()
+		return nil, fmt.Errorf("tar file %q: too small to be an eStargz archive", index.f.Name())
+	}
+
+	footer := make([]byte, eStargzFooterSize)
+	if _, err := index.f.ReadAt(footer, stat.Size()-eStargzFooterSize); err != nil {
+		index.Close()
+		return nil, fmt.Errorf("tar file %q: cannot read eStargz footer: %w", index.f.Name(), err)
+	}
+	tocOffset := int64(binary.BigEndian.Uint64(footer))
+
+	toc, err := readEStargzTOC(index.f, tocOffset)
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+
+	txn := iradix.New[seekableElement]().Txn()
+	type accumEntry struct {
+		header *tar.Header
+		chunks []estargzChunk // keyed by logical chunkOffset, sorted below.
+		order  []int64        // the chunkOffset each entry in chunks was recorded at.
+	}
+	accum := map[string]*accumEntry{}
+	names := make([]string, 0, len(toc.Entries))
+	for _, entry := range toc.Entries {
+		if entry.Name == eStargzTOCEntryName {
+			continue
+		}
+		a, ok := accum[entry.Name]
+		if !ok {
+			typeflag, err := estargzTypeflag(entry.Type)
+			if err != nil {
+				index.Close()
+				return nil, fmt.Errorf("tar file %q: entry %q: %w", index.f.Name(), entry.Name, err)
+			}
+			a = &accumEntry{
+				header: &tar.Header{
+					Name:     entry.Name,
+					Typeflag: typeflag,
+					Mode:     entry.Mode,
+					ModTime:  entry.ModTime,
+					Linkname: entry.Linkname,
+				},
+			}
+			accum[entry.Name] = a
+			names = append(names, entry.Name)
+		}
+		size := entry.ChunkSize
+		if size == 0 {
+			size = entry.Size
+		}
+		a.chunks = append(a.chunks, estargzChunk{MemberOffset: entry.Offset, Size: size})
+		a.order = append(a.order, entry.ChunkOffset)
+	}
+	for _, name := range names {
+		a := accum[name]
+		sort.Sort(&chunksByOffset{order: a.order, chunks: a.chunks})
+		var total int64
+		for i, c := range a.chunks {
+			if end := a.order[i] + c.Size; end > total {
+				total = end
+			}
+		}
+		a.header.Size = total
+		txn.Insert([]byte(name), seekableElement{
+			Header: a.header,
+			Offset: a.chunks[0].MemberOffset,
+			Chunks: a.chunks,
+		})
+	}
+	index.tree = txn.Commit()
+	return index, nil
+}
+
+// chunksByOffset sorts a set of chunks (and their parallel logical
+// chunkOffset) into ascending logical order, so that concatenating their
+// content in that order reassembles the entry's content.
+type chunksByOffset struct {
+	order  []int64
+	chunks []estargzChunk
+}
+
+func (s *chunksByOffset) Len() int { return len(s.chunks) }
+func (s *chunksByOffset) Less(i, j int) bool {
+	return s.order[i] < s.order[j]
+}
+func (s *chunksByOffset) Swap(i, j int) {
+	s.order[i], s.order[j] = s.order[j], s.order[i]
+	s.chunks[i], s.chunks[j] = s.chunks[j], s.chunks[i]
+}
+
+// readEStargzTOC seeks to the gzip member at tocOffset, decompresses it, and
+// decodes the TOC entry's JSON payload.
+func readEStargzTOC(f *os.File, tocOffset int64) (*estargzTOC, error) {
+	hdr, r, err := newEStargzEntryReader(f, tocOffset)
+	if err != nil {
+		return nil, fmt.Errorf("tar file %q: cannot open eStargz TOC: %w", f.Name(), err)
+	}
+	defer r.Close()
+	if hdr.Name != eStargzTOCEntryName {
+		return nil, fmt.Errorf("tar file %q: expected eStargz TOC entry %q, got %q",
+			f.Name(), eStargzTOCEntryName, hdr.Name)
+	}
+	var toc estargzTOC
+	if err := json.NewDecoder(r).Decode(&toc); err != nil {
+		return nil, fmt.Errorf("tar file %q: cannot decode eStargz TOC: %w", f.Name(), err)
+	}
+	return &toc, nil
+}
+
+// estargzEntryReader is an io.ReadCloser that decompresses a single gzip
+// member of an eStargz/stargz archive, starting at a particular byte offset,
+// and yields the contents of the single tar entry stored in that member.
+type estargzEntryReader struct {
+	f  *os.File
+	gz *gzip.Reader
+	tr *tar.Reader
+}
+
+// newEStargzEntryReader opens a fresh gzip.Reader at offset within f and
+// reads the single tar header stored at the beginning of that gzip member,
+// returning both the header and a reader for the entry's content.
+func newEStargzEntryReader(f *os.File, offset int64) (*tar.Header, *estargzEntryReader, error) {
+	duplicatedfd, err := unix.Dup(int(f.Fd()))
+	if err != nil {
+		return nil, nil, err
+	}
+	nf := os.NewFile(uintptr(duplicatedfd), fmt.Sprintf("%s@%d", f.Name(), offset))
+	if _, err := nf.Seek(offset, io.SeekStart); err != nil {
+		nf.Close()
+		return nil, nil, err
+	}
+	gz, err := gzip.NewReader(nf)
+	if err != nil {
+		nf.Close()
+		return nil, nil, err
+	}
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil {
+		gz.Close()
+		nf.Close()
+		return nil, nil, err
+	}
+	return hdr, &estargzEntryReader{f: nf, gz: gz, tr: tr}, nil
+}
+
+// Read reads the current entry's content, bounded by the tar header's
+// advertised size.
+func (r *estargzEntryReader) Read(p []byte) (int, error) {
+	return r.tr.Read(p)
+}
+
+// Close releases the gzip.Reader and the underlying duplicated file
+// descriptor.
+func (r *estargzEntryReader) Close() error {
+	gzErr := r.gz.Close()
+	fErr := r.f.Close()
+	if gzErr != nil {
+		return gzErr
+	}
+	return fErr
+}
+
+// estargzChunkedReader is an io.ReadCloser that reassembles a (possibly
+// multi-chunk) eStargz/stargz entry's content by reading its chunks' gzip
+// members one after another, in the logical order recorded in chunks, so
+// that large files split across several independently decompressable
+// chunks read back as a single contiguous stream.
+type estargzChunkedReader struct {
+	f      *os.File
+	chunks []estargzChunk
+	next   int                 // index into chunks of the next member to open.
+	cur    *estargzEntryReader // the member currently being read, if any.
+}
+
+// newEStargzChunkedReader returns an estargzChunkedReader over chunks,
+// which must already be in ascending logical order (see
+// [NewFromEStargz]/chunksByOffset).
+func newEStargzChunkedReader(f *os.File, chunks []estargzChunk) *estargzChunkedReader {
+	return &estargzChunkedReader{f: f, chunks: chunks}
+}
+
+// Read reads from the current chunk's member, transparently advancing to
+// the next chunk's member once the current one is exhausted.
+func (r *estargzChunkedReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if r.next >= len(r.chunks) {
+				return 0, io.EOF
+			}
+			_, cur, err := newEStargzEntryReader(r.f, r.chunks[r.next].MemberOffset)
+			if err != nil {
+				return 0, err
+			}
+			r.cur = cur
+			r.next++
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			closeErr := r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			if closeErr != nil {
+				return 0, closeErr
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close releases the currently open chunk member's resources, if any.
+func (r *estargzChunkedReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	return r.cur.Close()
+}
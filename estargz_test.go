@@ -0,0 +1,229 @@
+// Copyright 2025 by Harald Albrecht
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gtar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/fdooze"
+	. "github.com/thediveo/success"
+)
+
+// writeEStargzMember gzip-compresses a single tar entry of its own, as used
+// by the eStargz/stargz layout to allow seeking straight to individual
+// entries.
+func writeEStargzMember(w io.Writer, hdr *tar.Header, content []byte) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(content); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeEStargzTestFile assembles a minimal eStargz/stargz archive containing
+// the given name/content pairs, plus a TOC and footer, and returns its path.
+func writeEStargzTestFile(files map[string][]byte) string {
+	var buf bytes.Buffer
+	entries := make([]estargzTOCEntry, 0, len(files))
+	for name, content := range files {
+		offset := int64(buf.Len())
+		hdr := &tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Size:     int64(len(content)),
+			Mode:     0o644,
+			ModTime:  time.Unix(0, 0),
+		}
+		Expect(writeEStargzMember(&buf, hdr, content)).To(Succeed())
+		entries = append(entries, estargzTOCEntry{
+			Name:   name,
+			Type:   "reg",
+			Size:   int64(len(content)),
+			Mode:   0o644,
+			Offset: offset,
+		})
+	}
+
+	tocOffset := int64(buf.Len())
+	toc := estargzTOC{Version: 1, Entries: entries}
+	tocJSON := Successful(json.Marshal(toc))
+	Expect(writeEStargzMember(&buf, &tar.Header{
+		Name:     eStargzTOCEntryName,
+		Typeflag: tar.TypeReg,
+		Size:     int64(len(tocJSON)),
+		Mode:     0o644,
+		ModTime:  time.Unix(0, 0),
+	}, tocJSON)).To(Succeed())
+
+	footer := make([]byte, eStargzFooterSize)
+	binary.BigEndian.PutUint64(footer, uint64(tocOffset))
+	buf.Write(footer)
+
+	tf := Successful(os.CreateTemp("", "testing-estargz-*"))
+	defer tf.Close()
+	Expect(io.Copy(tf, &buf)).Error().NotTo(HaveOccurred())
+	return tf.Name()
+}
+
+// writeChunkedEStargzTestFile assembles a minimal eStargz/stargz archive
+// containing a single entry named name, split across len(chunks)
+// independently gzip-compressed members, plus a TOC and footer, and returns
+// its path.
+func writeChunkedEStargzTestFile(name string, chunks [][]byte) string {
+	var buf bytes.Buffer
+	entries := make([]estargzTOCEntry, 0, len(chunks))
+	var chunkOffset int64
+	for _, content := range chunks {
+		memberOffset := int64(buf.Len())
+		hdr := &tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Size:     int64(len(content)),
+			Mode:     0o644,
+			ModTime:  time.Unix(0, 0),
+		}
+		Expect(writeEStargzMember(&buf, hdr, content)).To(Succeed())
+		entries = append(entries, estargzTOCEntry{
+			Name:        name,
+			Type:        "reg",
+			Mode:        0o644,
+			Offset:      memberOffset,
+			ChunkOffset: chunkOffset,
+			ChunkSize:   int64(len(content)),
+		})
+		chunkOffset += int64(len(content))
+	}
+
+	tocOffset := int64(buf.Len())
+	toc := estargzTOC{Version: 1, Entries: entries}
+	tocJSON := Successful(json.Marshal(toc))
+	Expect(writeEStargzMember(&buf, &tar.Header{
+		Name:     eStargzTOCEntryName,
+		Typeflag: tar.TypeReg,
+		Size:     int64(len(tocJSON)),
+		Mode:     0o644,
+		ModTime:  time.Unix(0, 0),
+	}, tocJSON)).To(Succeed())
+
+	footer := make([]byte, eStargzFooterSize)
+	binary.BigEndian.PutUint64(footer, uint64(tocOffset))
+	buf.Write(footer)
+
+	tf := Successful(os.CreateTemp("", "testing-estargz-chunked-*"))
+	defer tf.Close()
+	Expect(io.Copy(tf, &buf)).Error().NotTo(HaveOccurred())
+	return tf.Name()
+}
+
+var _ = Describe("eStargz/stargz TOC-based indexing", Ordered, func() {
+
+	var estargzPath string
+
+	BeforeAll(func() {
+		estargzPath = writeEStargzTestFile(map[string][]byte{
+			"foo":     []byte("foo"),
+			"bar/baz": []byte("1234567890"),
+		})
+		DeferCleanup(func() {
+			Expect(os.Remove(estargzPath)).To(Succeed())
+		})
+	})
+
+	BeforeEach(func() {
+		goodfds := Filedescriptors()
+		DeferCleanup(func() {
+			Eventually(Filedescriptors).Within(2 * time.Second).ProbeEvery(100 * time.Millisecond).
+				ShouldNot(HaveLeakedFds(goodfds))
+		})
+	})
+
+	It("decodes a TOC entry's type as carried by real eStargz/stargz archives", func() {
+		var entry estargzTOCEntry
+		Expect(json.Unmarshal([]byte(`{"name":"foo","type":"reg","size":3}`), &entry)).To(Succeed())
+		Expect(entry.Type).To(Equal("reg"))
+
+		Expect(estargzTypeflag("reg")).To(Equal(byte(tar.TypeReg)))
+		Expect(estargzTypeflag("dir")).To(Equal(byte(tar.TypeDir)))
+		Expect(estargzTypeflag("symlink")).To(Equal(byte(tar.TypeSymlink)))
+		Expect(estargzTypeflag("chunk")).To(Equal(byte(tar.TypeReg)))
+		Expect(estargzTypeflag("bogus")).Error().To(HaveOccurred())
+	})
+
+	It("rejects a file too small to carry a footer", func() {
+		f := Successful(os.CreateTemp("", "testing-estargz-tiny-*"))
+		defer f.Close()
+		defer os.Remove(f.Name())
+		Expect(NewFromEStargz(f)).Error().To(HaveOccurred())
+	})
+
+	It("builds an index from the TOC without scanning the archive", func() {
+		f := Successful(os.Open(estargzPath))
+		defer f.Close()
+		i := Successful(NewFromEStargz(f))
+		defer i.Close()
+
+		Expect(i.AllRegularFilePaths()).To(ConsistOf("foo", "bar/baz"))
+
+		r := Successful(i.Open("foo"))
+		defer r.Close()
+		Expect(io.ReadAll(r)).To(Equal([]byte("foo")))
+
+		r2 := Successful(i.Open("bar/baz"))
+		defer r2.Close()
+		Expect(io.ReadAll(r2)).To(Equal([]byte("1234567890")))
+	})
+
+	It("fails to open an unknown entry", func() {
+		f := Successful(os.Open(estargzPath))
+		defer f.Close()
+		i := Successful(NewFromEStargz(f))
+		defer i.Close()
+
+		Expect(i.Open("nada-nothing-nix")).Error().To(HaveOccurred())
+	})
+
+	It("reassembles a large file split across several chunks", func() {
+		chunkedPath := writeChunkedEStargzTestFile("big", [][]byte{
+			[]byte("AAAA"), []byte("BBBB"),
+		})
+		defer os.Remove(chunkedPath)
+
+		f := Successful(os.Open(chunkedPath))
+		defer f.Close()
+		i := Successful(NewFromEStargz(f))
+		defer i.Close()
+
+		r := Successful(i.Open("big"))
+		defer r.Close()
+		Expect(io.ReadAll(r)).To(Equal([]byte("AAAABBBB")))
+	})
+
+})
@@ -0,0 +1,369 @@
+// Copyright 2025 by Harald Albrecht
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gtar
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSymlinkDepth bounds the number of tar.TypeSymlink hops [Index.resolve]
+// will follow before giving up, guarding against symlink cycles.
+const maxSymlinkDepth = 40
+
+// FS returns an fs.FS adapter for this Index, additionally implementing
+// fs.ReadDirFS, fs.StatFS, fs.ReadFileFS, and fs.SubFS, so that the tarball
+// indexed by i can be used directly with fs.WalkDir, fs.Glob, http.FS,
+// template.ParseFS, and other io/fs-based APIs. Symlinks recorded as
+// tar.TypeSymlink are resolved transparently.
+func (i *Index) FS() fs.FS {
+	return &indexFS{idx: i}
+}
+
+// indexFS adapts an Index to the io/fs.FS family of interfaces, optionally
+// scoped to a sub-tree rooted at prefix (see [Index.FS] and [indexFS.Sub]).
+type indexFS struct {
+	idx    *Index
+	prefix string // "" or "." for the unscoped root of idx.
+}
+
+var (
+	_ fs.FS         = (*indexFS)(nil)
+	_ fs.ReadDirFS  = (*indexFS)(nil)
+	_ fs.StatFS     = (*indexFS)(nil)
+	_ fs.ReadFileFS = (*indexFS)(nil)
+	_ fs.SubFS      = (*indexFS)(nil)
+)
+
+// scope returns this indexFS's prefix normalized to "." for the unscoped
+// root, for use as the sandbox boundary passed to [Index.resolve] and
+// [Index.statPath].
+func (ifs *indexFS) scope() string {
+	if ifs.prefix == "" {
+		return "."
+	}
+	return ifs.prefix
+}
+
+// fullPath validates name against fs.ValidPath and resolves it against this
+// indexFS's prefix, returning the corresponding path within the underlying
+// Index.
+func (ifs *indexFS) fullPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if ifs.prefix == "" || ifs.prefix == "." {
+		return name, nil
+	}
+	if name == "." {
+		return ifs.prefix, nil
+	}
+	return ifs.prefix + "/" + name, nil
+}
+
+// Open implements fs.FS, returning an fs.File for the named regular file,
+// symlink target, or directory.
+func (ifs *indexFS) Open(name string) (fs.File, error) {
+	full, err := ifs.fullPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if full == "." {
+		return &indexDir{fsys: ifs, name: "."}, nil
+	}
+	resolved, el, err := ifs.idx.resolve(ifs.scope(), full)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			if _, isDir := ifs.idx.buildDirTree()[full]; isDir {
+				return &indexDir{fsys: ifs, name: full}, nil
+			}
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if el.FileInfo().IsDir() {
+		return &indexDir{fsys: ifs, name: full}, nil
+	}
+	r, err := ifs.idx.Open(resolved)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &indexFile{ReadCloser: r, info: el.FileInfo()}, nil
+}
+
+// Stat implements fs.StatFS.
+func (ifs *indexFS) Stat(name string) (fs.FileInfo, error) {
+	full, err := ifs.fullPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := ifs.idx.statPath(ifs.scope(), full)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// ReadDir implements fs.ReadDirFS, using the Index's directory tree built by
+// [Index.buildDirTree] instead of scanning all entries.
+func (ifs *indexFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := ifs.fullPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	children, ok := ifs.idx.buildDirTree()[full]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		childPath := child
+		if full != "." {
+			childPath = full + "/" + child
+		}
+		info, err := ifs.idx.direntInfo(childPath)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	return entries, nil
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (ifs *indexFS) ReadFile(name string) ([]byte, error) {
+	f, err := ifs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, ok := f.(io.Reader)
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	return io.ReadAll(r)
+}
+
+// Sub implements fs.SubFS, returning an indexFS scoped to dir; it shares the
+// underlying Index (and thus its file descriptor) with ifs.
+func (ifs *indexFS) Sub(dir string) (fs.FS, error) {
+	full, err := ifs.fullPath("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	if full != "." {
+		if _, isDir := ifs.idx.buildDirTree()[full]; !isDir {
+			return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrNotExist}
+		}
+	}
+	return &indexFS{idx: ifs.idx, prefix: full}, nil
+}
+
+// buildDirTree lazily builds and caches the directory-to-children map used
+// by ReadDir and Sub, keyed by directory path ("." for the root) with
+// sorted child base names. Intermediate directories implied by a deep path
+// but without an explicit tar entry of their own are synthesized, so that
+// ReadDir and Stat work regardless of whether the original tar stream
+// recorded directory entries.
+func (i *Index) buildDirTree() map[string][]string {
+	i.dirsOnce.Do(func() {
+		dirs := map[string]map[string]struct{}{".": {}}
+		for p := range i.All() {
+			p = path.Clean(p)
+			for p != "." {
+				dir := path.Dir(p)
+				if _, ok := dirs[dir]; !ok {
+					dirs[dir] = map[string]struct{}{}
+				}
+				dirs[dir][path.Base(p)] = struct{}{}
+				p = dir
+			}
+		}
+		sorted := make(map[string][]string, len(dirs))
+		for dir, children := range dirs {
+			names := make([]string, 0, len(children))
+			for name := range children {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			sorted[dir] = names
+		}
+		i.dirs = sorted
+	})
+	return i.dirs
+}
+
+// direntInfo returns the fs.FileInfo for childPath exactly as recorded in
+// the Index, with lstat semantics: a symlink is reported as a symlink,
+// under its own name, without following it. childPath must already be a
+// "/"-joined, fs.ValidPath-clean path known to be a directory's child (see
+// [Index.buildDirTree]), so it either has its own tar entry or is a
+// synthesized intermediate directory; unlike [Index.statPath], it never
+// has a symlink to resolve and so cannot fail with fs.ErrNotExist from a
+// dangling or out-of-scope target.
+func (i *Index) direntInfo(childPath string) (fs.FileInfo, error) {
+	if el, ok := i.tree.Get([]byte(i.fullKey(childPath))); ok {
+		return el.FileInfo(), nil
+	}
+	if _, isDir := i.buildDirTree()[childPath]; isDir {
+		return syntheticDirInfo{name: path.Base(childPath)}, nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+// withinScope reports whether name lies at or below scope, as required of
+// every hop [Index.resolve] takes; scope "." matches the whole tree.
+func withinScope(scope, name string) bool {
+	return scope == "." || name == scope || strings.HasPrefix(name, scope+"/")
+}
+
+// resolve looks up name in the Index, transparently following
+// tar.TypeSymlink entries until a non-symlink entry is found, up to
+// [maxSymlinkDepth] hops. scope is the archive-root-relative path a caller
+// such as an [indexFS] is sandboxed to ("." for the unscoped root): an
+// absolute Linkname is resolved relative to scope rather than the archive
+// root, and any hop - absolute or relative - that would leave scope fails
+// with fs.ErrNotExist, so that a symlink can never be used to escape a
+// [indexFS.Sub] subtree. resolve returns the resolved name together with
+// its seekableElement, or fs.ErrNotExist if name (after resolving any
+// symlinks) isn't present in the Index or within scope.
+func (i *Index) resolve(scope, name string) (string, seekableElement, error) {
+	for depth := 0; ; depth++ {
+		if depth > maxSymlinkDepth {
+			return "", seekableElement{}, fmt.Errorf(
+				"tar file %q: too many levels of symbolic links resolving %q",
+				i.f.Name(), name)
+		}
+		if !withinScope(scope, name) {
+			return "", seekableElement{}, fs.ErrNotExist
+		}
+		el, ok := i.tree.Get([]byte(i.fullKey(name)))
+		if !ok {
+			return "", seekableElement{}, fs.ErrNotExist
+		}
+		if el.Typeflag != tar.TypeSymlink {
+			return name, el, nil
+		}
+		if path.IsAbs(el.Linkname) {
+			name = path.Join(scope, path.Clean(el.Linkname[1:]))
+		} else {
+			name = path.Join(path.Dir(name), el.Linkname)
+		}
+	}
+}
+
+// statPath returns the fs.FileInfo for name, which must already be a
+// "/"-joined, fs.ValidPath-clean path (or "."); it transparently resolves
+// symlinks (confined to scope, see [Index.resolve]) and synthesizes
+// fs.FileInfo for directories implied by, but not explicitly recorded in,
+// the tar stream.
+func (i *Index) statPath(scope, name string) (fs.FileInfo, error) {
+	if name == "." {
+		return syntheticDirInfo{name: "."}, nil
+	}
+	_, el, err := i.resolve(scope, name)
+	if err == nil {
+		return el.FileInfo(), nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, isDir := i.buildDirTree()[name]; isDir {
+			return syntheticDirInfo{name: path.Base(name)}, nil
+		}
+	}
+	return nil, err
+}
+
+// syntheticDirInfo is the fs.FileInfo of a directory implied by a tar
+// entry's path but without an explicit directory entry of its own in the
+// tar stream.
+type syntheticDirInfo struct {
+	name string
+}
+
+var _ fs.FileInfo = syntheticDirInfo{}
+
+func (d syntheticDirInfo) Name() string       { return d.name }
+func (d syntheticDirInfo) Size() int64        { return 0 }
+func (d syntheticDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (d syntheticDirInfo) ModTime() time.Time { return time.Time{} }
+func (d syntheticDirInfo) IsDir() bool        { return true }
+func (d syntheticDirInfo) Sys() any           { return nil }
+
+// indexFile adapts the io.ReadCloser returned by [Index.Open] to fs.File.
+type indexFile struct {
+	io.ReadCloser
+	info fs.FileInfo
+}
+
+var _ fs.File = (*indexFile)(nil)
+
+func (f *indexFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+// indexDir is the fs.File (and fs.ReadDirFile) representing a directory
+// opened through an indexFS.
+type indexDir struct {
+	fsys *indexFS
+	name string
+
+	entries []fs.DirEntry // lazily populated by the first ReadDir call.
+	read    int
+}
+
+var (
+	_ fs.File        = (*indexDir)(nil)
+	_ fs.ReadDirFile = (*indexDir)(nil)
+)
+
+func (d *indexDir) Stat() (fs.FileInfo, error) {
+	return d.fsys.idx.statPath(d.fsys.scope(), d.name)
+}
+
+func (d *indexDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *indexDir) Close() error { return nil }
+
+// ReadDir implements fs.ReadDirFile, returning up to n directory entries per
+// call, or all remaining entries if n <= 0.
+func (d *indexDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		// d.name already includes d.fsys's prefix, so read it through an
+		// unscoped indexFS instead of prefixing it a second time.
+		entries, err := (&indexFS{idx: d.fsys.idx}).ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+	}
+	if n <= 0 {
+		rest := d.entries[d.read:]
+		d.read = len(d.entries)
+		return rest, nil
+	}
+	if d.read >= len(d.entries) {
+		return nil, io.EOF
+	}
+	end := min(d.read+n, len(d.entries))
+	out := d.entries[d.read:end]
+	d.read = end
+	return out, nil
+}
@@ -0,0 +1,259 @@
+// Copyright 2025 by Harald Albrecht
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gtar
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/fdooze"
+	. "github.com/thediveo/success"
+)
+
+// writeFSTestFile assembles a tar archive from name/content pairs (a nil
+// content marks a directory entry) plus an explicit symlink map, and
+// returns its path. Directory entries are written only for the paths given
+// with nil content, so that the parents of other paths stay implicit and the
+// fs.FS adapter's synthesized directories are exercised too.
+func writeFSTestFile(files map[string][]byte, symlinks map[string]string) string {
+	tf := Successful(os.CreateTemp("", "testing-fs-*"))
+	defer tf.Close()
+	tw := tar.NewWriter(tf)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content := files[name]
+		hdr := &tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Size:     int64(len(content)),
+			Mode:     0o644,
+			ModTime:  time.Unix(0, 0),
+		}
+		if content == nil {
+			hdr.Typeflag = tar.TypeDir
+			hdr.Size = 0
+		}
+		Expect(tw.WriteHeader(hdr)).To(Succeed())
+		if content != nil {
+			Expect(tw.Write(content)).Error().NotTo(HaveOccurred())
+		}
+	}
+	linknames := make([]string, 0, len(symlinks))
+	for name := range symlinks {
+		linknames = append(linknames, name)
+	}
+	sort.Strings(linknames)
+	for _, name := range linknames {
+		Expect(tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeSymlink,
+			Linkname: symlinks[name],
+			Mode:     0o777,
+			ModTime:  time.Unix(0, 0),
+		})).To(Succeed())
+	}
+	Expect(tw.Close()).To(Succeed())
+	return tf.Name()
+}
+
+var _ = Describe("the Index as an fs.FS", Ordered, func() {
+
+	var tarballPath string
+
+	BeforeAll(func() {
+		tarballPath = writeFSTestFile(map[string][]byte{
+			"foo":         []byte("foo"),
+			"bar/baz":     []byte("1234567890"),
+			"bar":         nil,              // explicit directory entry for "bar"
+			"deep/in/dir": []byte("nested"), // "deep" and "deep/in" are implicit
+			"a":           nil,
+			"secret":      []byte("top secret"),
+		}, map[string]string{
+			"link-to-foo":     "foo",
+			"abs-to-baz":      "/bar/baz",
+			"a/escape-rel":    "../secret",
+			"a/escape-abs":    "/secret",
+			"a/escape-nested": "../../secret",
+		})
+		DeferCleanup(func() {
+			Expect(os.Remove(tarballPath)).To(Succeed())
+		})
+	})
+
+	BeforeEach(func() {
+		goodfds := Filedescriptors()
+		DeferCleanup(func() {
+			Eventually(Filedescriptors).Within(2 * time.Second).ProbeEvery(100 * time.Millisecond).
+				ShouldNot(HaveLeakedFds(goodfds))
+		})
+	})
+
+	It("reads a regular file via Open and Stat", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		iofs := i.FS()
+
+		f := Successful(iofs.Open("foo"))
+		defer f.Close()
+		Expect(io.ReadAll(f)).To(Equal([]byte("foo")))
+
+		info := Successful(iofs.(fs.StatFS).Stat("foo"))
+		Expect(info.IsDir()).To(BeFalse())
+		Expect(info.Size()).To(Equal(int64(3)))
+	})
+
+	It("reads a file via ReadFileFS", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		iofs := i.FS()
+
+		Expect(iofs.(fs.ReadFileFS).ReadFile("bar/baz")).To(Equal([]byte("1234567890")))
+	})
+
+	It("lists an explicit directory via ReadDirFS", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		iofs := i.FS()
+
+		entries := Successful(iofs.(fs.ReadDirFS).ReadDir("bar"))
+		names := make([]string, len(entries))
+		for idx, entry := range entries {
+			names[idx] = entry.Name()
+		}
+		Expect(names).To(Equal([]string{"baz"}))
+	})
+
+	It("synthesizes implicit intermediate directories", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		iofs := i.FS()
+
+		info := Successful(iofs.(fs.StatFS).Stat("deep/in"))
+		Expect(info.IsDir()).To(BeTrue())
+
+		entries := Successful(iofs.(fs.ReadDirFS).ReadDir("deep/in"))
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Name()).To(Equal("dir"))
+	})
+
+	It("resolves relative and absolute symlinks transparently", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		iofs := i.FS()
+
+		f := Successful(iofs.Open("link-to-foo"))
+		defer f.Close()
+		Expect(io.ReadAll(f)).To(Equal([]byte("foo")))
+
+		f2 := Successful(iofs.Open("abs-to-baz"))
+		defer f2.Close()
+		Expect(io.ReadAll(f2)).To(Equal([]byte("1234567890")))
+	})
+
+	It("lists a symlink under its own name without resolving it", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		iofs := i.FS()
+
+		entries := Successful(iofs.(fs.ReadDirFS).ReadDir("."))
+		names := make([]string, len(entries))
+		for idx, entry := range entries {
+			names[idx] = entry.Name()
+		}
+		Expect(names).To(ContainElements("foo", "link-to-foo", "abs-to-baz"))
+
+		var link fs.DirEntry
+		for _, entry := range entries {
+			if entry.Name() == "link-to-foo" {
+				link = entry
+			}
+		}
+		Expect(link).NotTo(BeNil())
+		Expect(link.Type() & fs.ModeSymlink).To(Equal(fs.ModeSymlink))
+	})
+
+	It("lists a directory whose symlinks dangle or escape scope", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		iofs := i.FS()
+
+		entries := Successful(iofs.(fs.ReadDirFS).ReadDir("a"))
+		names := make([]string, len(entries))
+		for idx, entry := range entries {
+			names[idx] = entry.Name()
+		}
+		Expect(names).To(ConsistOf("escape-rel", "escape-abs", "escape-nested"))
+	})
+
+	It("walks the whole tree with fs.WalkDir", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		iofs := i.FS()
+
+		var paths []string
+		Expect(fs.WalkDir(iofs, ".", func(p string, d fs.DirEntry, err error) error {
+			Expect(err).NotTo(HaveOccurred())
+			if p != "." {
+				paths = append(paths, p)
+			}
+			return nil
+		})).To(Succeed())
+		Expect(paths).To(ContainElements("foo", "bar", "bar/baz", "deep", "deep/in", "deep/in/dir"))
+	})
+
+	It("scopes lookups via Sub", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		iofs := i.FS()
+
+		sub := Successful(iofs.(fs.SubFS).Sub("deep"))
+		Expect(sub.(fs.ReadFileFS).ReadFile("in/dir")).To(Equal([]byte("nested")))
+	})
+
+	It("confines symlinks within a Sub scope instead of escaping it", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		iofs := i.FS()
+
+		sub := Successful(iofs.(fs.SubFS).Sub("a"))
+
+		Expect(sub.Open("escape-rel")).Error().To(HaveOccurred())
+		Expect(sub.Open("escape-abs")).Error().To(HaveOccurred())
+		Expect(sub.Open("escape-nested")).Error().To(HaveOccurred())
+
+		_, err := sub.(fs.StatFS).Stat("escape-rel")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects invalid and unknown paths", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+		iofs := i.FS()
+
+		Expect(iofs.Open("../escape")).Error().To(HaveOccurred())
+		Expect(iofs.Open("nada-nothing-nix")).Error().To(HaveOccurred())
+	})
+
+})
@@ -20,15 +20,36 @@ import (
 	"io"
 	"io/fs"
 	"iter"
-	"maps"
 	"os"
+	"path"
+	"sync"
 
+	iradix "github.com/hashicorp/go-immutable-radix/v2"
+	"github.com/thediveo/gtar/digest"
 	"golang.org/x/sys/unix"
 )
 
 type Index struct {
-	idx map[string]seekableElement
-	f   *os.File
+	tree       *iradix.Tree[seekableElement]
+	f          *os.File
+	compressed bool // true if tree's element offsets point to gzip members, see [NewFromEStargz].
+
+	// prefix scopes this Index to a sub-tree of tree, as created by
+	// [Index.Sub]; "" for an unscoped, top-level Index. Lookups join name
+	// onto prefix, and iterators strip prefix back off again, so that a
+	// scoped Index behaves exactly like a freshly built one rooted at
+	// prefix, while still sharing tree (and thus f) with its parent.
+	prefix string
+
+	dirsOnce sync.Once
+	dirs     map[string][]string // directory path ("." for the root) to sorted child base names, see [Index.FS].
+
+	digestsMu sync.Mutex
+	digests   map[string]digest.Digest // per-entry digest cache, see [Index.Digest].
+
+	archiveDigestOnce sync.Once
+	archiveDigest     digest.Digest
+	archiveDigestErr  error
 }
 
 // seekableElement describes an element inside a tar file so that the element's
@@ -36,6 +57,17 @@ type Index struct {
 type seekableElement struct {
 	*tar.Header
 	Offset int64 // offset from beginning of file to contents.
+
+	// Sparse holds the fragment map of a GNU/PAX sparse file, in logical
+	// file order; nil for regular, non-sparse entries. When set, Offset is
+	// the file position the first fragment's data starts at, with
+	// subsequent fragments following it back to back.
+	Sparse []sparseFragment
+
+	// Chunks holds the gzip member locations of an eStargz/stargz entry, in
+	// logical order; nil unless this element came from [NewFromEStargz].
+	// Offset duplicates Chunks[0].MemberOffset for such elements.
+	Chunks []estargzChunk
 }
 
 // New returns a new TAR file Index object for the specified file path. Please
@@ -63,9 +95,9 @@ func NewFromFile(tarf *os.File) (*Index, error) {
 		return nil, err
 	}
 	index := &Index{
-		idx: map[string]seekableElement{},
-		f:   os.NewFile(uintptr(newfd), tarf.Name()),
+		f: os.NewFile(uintptr(newfd), tarf.Name()),
 	}
+	txn := iradix.New[seekableElement]().Txn()
 
 	junk := make([]byte, 4096)
 
@@ -73,6 +105,15 @@ func NewFromFile(tarf *os.File) (*Index, error) {
 	// content offsets within the tar file.
 	tarr := tar.NewReader(index.f)
 	for {
+		// Remember the position of the (next) header block itself: for old
+		// GNU sparse files, the fragment map is embedded in this very block
+		// and can only be recovered by re-reading it directly from the file,
+		// as archive/tar does not expose it.
+		headerPos, err := index.f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			index.Close()
+			return nil, err
+		}
 		// Read the (next) header; if that succeeds, due to the (512 bytes)
 		// block structure of the tar format, the file read position will be at
 		// the beginning of the file contents.
@@ -109,14 +150,31 @@ func NewFromFile(tarf *os.File) (*Index, error) {
 			return nil, err
 		}
 		if end != pos+hdr.Size {
-			index.Close()
-			return nil, fmt.Errorf("unsupported sparse file %q", hdr.Name)
+			// GNU tar (and PAX) sparse files report their logical size in
+			// the header, but only store the non-hole fragments on disk.
+			// Recover the fragment map instead of bailing out.
+			fragments, rawDataStart, serr := parseSparseMap(index.f, hdr, headerPos, pos)
+			if serr != nil {
+				index.Close()
+				return nil, fmt.Errorf("unsupported sparse file %q: %w", hdr.Name, serr)
+			}
+			if fragments == nil {
+				index.Close()
+				return nil, fmt.Errorf("unsupported sparse file %q", hdr.Name)
+			}
+			txn.Insert([]byte(hdr.Name), seekableElement{
+				Header: hdr,
+				Offset: rawDataStart,
+				Sparse: fragments,
+			})
+			continue
 		}
-		index.idx[hdr.Name] = seekableElement{
+		txn.Insert([]byte(hdr.Name), seekableElement{
 			Header: hdr,
 			Offset: pos,
-		}
+		})
 	}
+	index.tree = txn.Commit()
 	return index, nil
 }
 
@@ -125,28 +183,41 @@ func (i *Index) Close() error {
 	return i.f.Close()
 }
 
+// fullKey joins name onto this Index's scope (see [Index.Sub]), returning
+// the corresponding key within the underlying, unscoped radix tree.
+func (i *Index) fullKey(name string) string {
+	if i.prefix == "" {
+		return name
+	}
+	return i.prefix + "/" + name
+}
+
 // Open the named regular file for reading, returning an io.ReadCloser.
 // Otherwise, return nil and an error. Please note that the caller is
 // responsible to call [io.ReadCloser.Close] when done in order to release
 // associated system resources.
 func (i *Index) Open(name string) (io.ReadCloser, error) {
-	el, ok := i.idx[name]
+	el, ok := i.tree.Get([]byte(i.fullKey(name)))
 	if !ok {
 		return nil, fmt.Errorf("tar file %q: no such element %q",
 			i.f.Name(), name)
 	}
+	if i.compressed {
+		return newEStargzChunkedReader(i.f, el.Chunks), nil
+	}
+	if el.Sparse != nil {
+		return newSparseReader(i.f, el.Offset, el.Size, el.Sparse)
+	}
 	return NewPartialReader(i.f, el.Offset, el.Size)
 }
 
-// AllRegularFilePaths returns an iterator over the (unsorted) paths of all
-// regular files in this Index.
+// AllRegularFilePaths returns an iterator over the paths of all regular
+// files in this Index, in lexicographic order.
 func (i *Index) AllRegularFilePaths() iter.Seq[string] {
 	return func(yield func(string) bool) {
-		for _, el := range i.idx {
-			if el.FileInfo().Mode() & ^fs.ModePerm != 0 {
-				continue
-			}
-			if !yield(el.Name) {
+		for path, info := range i.AllRegularFiles() {
+			_ = info
+			if !yield(path) {
 				break
 			}
 		}
@@ -154,11 +225,23 @@ func (i *Index) AllRegularFilePaths() iter.Seq[string] {
 }
 
 // All returns an iterator over all paths and their FileInfo elements for
-// regular files and directories in this tarball Index.
+// regular files and directories in this tarball Index, in lexicographic
+// order.
 func (i *Index) All() iter.Seq2[string, fs.FileInfo] {
 	return func(yield func(string, fs.FileInfo) bool) {
-		for path := range maps.Keys(i.idx) {
-			if !yield(path, i.idx[path].FileInfo()) {
+		root := i.tree.Root()
+		prefixKey := i.prefix
+		if prefixKey != "" {
+			prefixKey += "/"
+		}
+		it := root.Iterator()
+		it.SeekPrefix([]byte(prefixKey))
+		for {
+			k, el, ok := it.Next()
+			if !ok {
+				break
+			}
+			if !yield(string(k[len(prefixKey):]), el.FileInfo()) {
 				break
 			}
 		}
@@ -166,7 +249,7 @@ func (i *Index) All() iter.Seq2[string, fs.FileInfo] {
 }
 
 // AllRegularFiles returns an iterator over all paths and their FileInfo
-// elements of regular files in this tarball Index.
+// elements of regular files in this tarball Index, in lexicographic order.
 func (i *Index) AllRegularFiles() iter.Seq2[string, fs.FileInfo] {
 	return func(yield func(string, fs.FileInfo) bool) {
 		for path, info := range i.All() {
@@ -179,3 +262,79 @@ func (i *Index) AllRegularFiles() iter.Seq2[string, fs.FileInfo] {
 		}
 	}
 }
+
+// AllUnder returns an iterator over all paths and their FileInfo elements,
+// relative to this Index (see [Index.Sub]), that are prefix itself or lie
+// below it (i.e. prefix or prefix+"/..."), in lexicographic order. It is a
+// cheap, allocation-light alternative to filtering [Index.All] by hand,
+// implemented as a single prefix-bounded walk of the underlying radix tree.
+func (i *Index) AllUnder(prefix string) iter.Seq2[string, fs.FileInfo] {
+	return func(yield func(string, fs.FileInfo) bool) {
+		base := i.fullKey(prefix)
+		walkKey := base
+		if walkKey != "" {
+			walkKey += "/"
+		}
+		stripLen := len(i.prefix)
+		if stripLen > 0 {
+			stripLen++ // also strip the separating "/".
+		}
+		root := i.tree.Root()
+
+		if el, ok := root.Get([]byte(base)); ok {
+			if !yield(base[stripLen:], el.FileInfo()) {
+				return
+			}
+		}
+
+		it := root.Iterator()
+		it.SeekPrefix([]byte(walkKey))
+		for {
+			k, el, ok := it.Next()
+			if !ok {
+				break
+			}
+			if !yield(string(k[stripLen:]), el.FileInfo()) {
+				break
+			}
+		}
+	}
+}
+
+// Glob returns an iterator over the paths of all regular files and
+// directories matching pattern, using [path.Match] semantics (as with
+// [fs.Glob]), in lexicographic order. Malformed patterns yield no paths, as
+// with [path.Match].
+func (i *Index) Glob(pattern string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return
+		}
+		for p := range i.All() {
+			matched, err := path.Match(pattern, p)
+			if err != nil {
+				return
+			}
+			if !matched {
+				continue
+			}
+			if !yield(p) {
+				break
+			}
+		}
+	}
+}
+
+// Sub returns an Index scoped to prefix: its Open, All, AllRegularFiles,
+// AllRegularFilePaths, AllUnder, and Glob behave as if it had been built
+// from just the sub-tree rooted at prefix, with paths reported relative to
+// prefix. Sub is cheap: it shares the underlying radix tree and file
+// descriptor with i instead of rescanning the archive.
+func (i *Index) Sub(prefix string) *Index {
+	return &Index{
+		tree:       i.tree,
+		f:          i.f,
+		compressed: i.compressed,
+		prefix:     i.fullKey(prefix),
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2025 by Harald Albrecht
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gtar
+
+import (
+	"io"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/fdooze"
+	. "github.com/thediveo/success"
+)
+
+var _ = Describe("prefix-scoped lookups", Ordered, func() {
+
+	var tarballPath string
+
+	BeforeAll(func() {
+		tarballPath = writeFSTestFile(map[string][]byte{
+			"a/foo": []byte("hello"),
+			"a/bar": []byte("world"),
+			"b/baz": []byte("!!"),
+		}, nil)
+		DeferCleanup(func() {
+			Expect(os.Remove(tarballPath)).To(Succeed())
+		})
+	})
+
+	BeforeEach(func() {
+		goodfds := Filedescriptors()
+		DeferCleanup(func() {
+			Eventually(Filedescriptors).Within(2 * time.Second).ProbeEvery(100 * time.Millisecond).
+				ShouldNot(HaveLeakedFds(goodfds))
+		})
+	})
+
+	It("iterates all paths at or below a prefix", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+
+		Expect(Keys(i.AllUnder("a"))).To(ConsistOf("a/foo", "a/bar"))
+		Expect(Keys(i.AllUnder("a/foo"))).To(ConsistOf("a/foo"))
+		Expect(Keys(i.AllUnder("nada"))).To(BeEmpty())
+	})
+
+	It("matches glob patterns", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+
+		Expect(i.Glob("a/*")).To(ConsistOf("a/foo", "a/bar"))
+		Expect(i.Glob("*/baz")).To(ConsistOf("b/baz"))
+		Expect(i.Glob("[")).To(BeEmpty())
+	})
+
+	It("scopes lookups to a sub-tree", func() {
+		i := Successful(New(tarballPath))
+		defer i.Close()
+
+		sub := i.Sub("a")
+		Expect(sub.AllRegularFilePaths()).To(ConsistOf("foo", "bar"))
+
+		f := Successful(sub.Open("foo"))
+		defer f.Close()
+		Expect(io.ReadAll(f)).To(Equal([]byte("hello")))
+
+		Expect(sub.Open("nada")).Error().To(HaveOccurred())
+	})
+
+})
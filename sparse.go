@@ -0,0 +1,334 @@
+// Copyright 2025 by Harald Albrecht
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gtar
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// tarBlockSize is the size in bytes of a single tar header (or header
+// extension) block, as mandated by the tar format.
+const tarBlockSize = 512
+
+// sparseFragment describes a single data fragment of a GNU/PAX sparse file:
+// the fragment's Offset and Size within the entry's logical (expanded)
+// content. Everything in between two fragments, as well as anything past the
+// last fragment up to the logical Size, is a hole and reads back as zero
+// bytes.
+type sparseFragment struct {
+	Offset int64
+	Size   int64
+}
+
+// parseSparseMap detects whether the entry just read by tarr.Next() is a
+// GNU/PAX sparse file and, if so, parses its fragment map. headerPos is the
+// file offset tarr.Next() started reading this entry's headers from (which,
+// when one or more PAX extended header blocks precede the entry's own
+// header, is the offset of the first of those, not of the entry's own
+// header), and dataPos is the file offset its content starts at, as already
+// used by [NewFromFile].
+//
+// It returns the fragment map (in logical file order) and the file offset
+// the packed fragment data actually starts at; for the old GNU format and
+// PAX 0.1, this is always dataPos. PAX 1.0 stores the fragment map as a
+// preamble of the content itself, but archive/tar's reader already consumes
+// that preamble internally while producing hdr, advancing dataPos past it;
+// parsing it ourselves therefore has to locate the entry's own header block
+// first and start reading right after it. A nil fragment slice means that
+// hdr does not describe a sparse file.
+func parseSparseMap(f *os.File, hdr *tar.Header, headerPos, dataPos int64) (fragments []sparseFragment, rawDataStart int64, err error) {
+	switch {
+	case hdr.Typeflag == tar.TypeGNUSparse:
+		fragments, err = parseOldGNUSparseMap(f, headerPos)
+		return fragments, dataPos, err
+	case hdr.PAXRecords["GNU.sparse.major"] == "1" && hdr.PAXRecords["GNU.sparse.minor"] == "0":
+		ownHeaderPos, serr := skipPAXHeaderBlocks(f, headerPos)
+		if serr != nil {
+			return nil, 0, serr
+		}
+		return parseGNUSparseMap1x0(f, ownHeaderPos+tarBlockSize)
+	case hdr.PAXRecords["GNU.sparse.map"] != "":
+		fragments, err = parseGNUSparseMap0x1(hdr.PAXRecords["GNU.sparse.map"])
+		return fragments, dataPos, err
+	}
+	return nil, 0, nil
+}
+
+// skipPAXHeaderBlocks scans forward from pos over any PAX extended header
+// (TypeXHeader) blocks and their (block-padded) bodies, returning the file
+// offset of the first block that is not itself a PAX extended header, i.e.
+// the entry's own header block.
+func skipPAXHeaderBlocks(f *os.File, pos int64) (int64, error) {
+	var blk [tarBlockSize]byte
+	for {
+		if _, err := f.ReadAt(blk[:], pos); err != nil {
+			return 0, fmt.Errorf("cannot read tar header at offset %d: %w", pos, err)
+		}
+		if blk[156] != tar.TypeXHeader {
+			return pos, nil
+		}
+		size, err := parseOctal(blk[124:136])
+		if err != nil {
+			return 0, fmt.Errorf("invalid PAX extended header size: %w", err)
+		}
+		pos += tarBlockSize + roundUpBlock(size)
+	}
+}
+
+// parseOldGNUSparseMap parses the sparse fragment map embedded in the old
+// (pre-PAX) GNU tar sparse header format: up to four (offset,length) entries
+// directly in the main header block, chained to further extension blocks (of
+// up to 21 entries each) when the header's "isextended" flag is set.
+func parseOldGNUSparseMap(f *os.File, headerPos int64) ([]sparseFragment, error) {
+	var blk [tarBlockSize]byte
+	if _, err := f.ReadAt(blk[:], headerPos); err != nil {
+		return nil, fmt.Errorf("cannot read GNU sparse header: %w", err)
+	}
+	// The main header stores 4 entries at offset 386, followed by the
+	// "isextended" flag byte at offset 482.
+	arr := blk[386:483]
+	var fragments []sparseFragment
+	for {
+		maxEntries := len(arr) / 24
+		for i := 0; i < maxEntries; i++ {
+			entry := arr[i*24 : i*24+24]
+			if entry[0] == 0x00 {
+				break
+			}
+			offset, err := parseOctal(entry[0:12])
+			if err != nil {
+				return nil, fmt.Errorf("invalid GNU sparse entry offset: %w", err)
+			}
+			length, err := parseOctal(entry[12:24])
+			if err != nil {
+				return nil, fmt.Errorf("invalid GNU sparse entry length: %w", err)
+			}
+			fragments = append(fragments, sparseFragment{Offset: offset, Size: length})
+		}
+		if arr[24*maxEntries] == 0 {
+			return fragments, nil
+		}
+		// An extension header follows immediately, holding up to 21 more
+		// entries plus its own "isextended" flag at offset 504.
+		headerPos += tarBlockSize
+		if _, err := f.ReadAt(blk[:], headerPos); err != nil {
+			return nil, fmt.Errorf("cannot read GNU sparse extension header: %w", err)
+		}
+		arr = blk[:]
+	}
+}
+
+// parseGNUSparseMap0x1 parses the sparse fragment map of GNU PAX sparse
+// format 0.1, stored as a single comma-separated "offset,length,..." PAX
+// record.
+func parseGNUSparseMap0x1(sparseMap string) ([]sparseFragment, error) {
+	fields := strings.Split(sparseMap, ",")
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("malformed GNU.sparse.map record %q", sparseMap)
+	}
+	fragments := make([]sparseFragment, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		offset, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed GNU.sparse.map record %q: %w", sparseMap, err)
+		}
+		length, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed GNU.sparse.map record %q: %w", sparseMap, err)
+		}
+		fragments = append(fragments, sparseFragment{Offset: offset, Size: length})
+	}
+	return fragments, nil
+}
+
+// parseGNUSparseMap1x0 parses the sparse fragment map of GNU PAX sparse
+// format 1.0, stored as a newline-terminated decimal preamble at the
+// beginning of the entry's content: the number of entries, followed by that
+// many (offset,length) pairs, padded with NUL bytes up to the next tar block
+// boundary. It returns the fragments as well as the file offset the actual
+// packed fragment data starts at, i.e. dataPos plus the (block-padded) size
+// of the preamble.
+func parseGNUSparseMap1x0(f *os.File, dataPos int64) ([]sparseFragment, int64, error) {
+	var buf bytes.Buffer
+	var blk [tarBlockSize]byte
+	pos := dataPos
+	readBlock := func() error {
+		if _, err := f.ReadAt(blk[:], pos); err != nil {
+			return fmt.Errorf("cannot read GNU sparse 1.0 map: %w", err)
+		}
+		buf.Write(blk[:])
+		pos += tarBlockSize
+		return nil
+	}
+	r := bufio.NewReader(&buf)
+	nextToken := func() (string, error) {
+		// ReadString consumes a partial token when it runs out of data, so
+		// keep it and complete it from the next block.
+		var tok string
+		for {
+			part, err := r.ReadString('\n')
+			tok += part
+			if err == nil {
+				return strings.TrimRight(tok, "\n"), nil
+			}
+			if err := readBlock(); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	numEntriesStr, err := nextToken()
+	if err != nil {
+		return nil, 0, err
+	}
+	numEntries, err := strconv.ParseInt(numEntriesStr, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid GNU sparse 1.0 entry count %q: %w", numEntriesStr, err)
+	}
+
+	fragments := make([]sparseFragment, 0, numEntries)
+	for i := int64(0); i < numEntries; i++ {
+		offsetStr, err := nextToken()
+		if err != nil {
+			return nil, 0, err
+		}
+		lengthStr, err := nextToken()
+		if err != nil {
+			return nil, 0, err
+		}
+		offset, err := strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid GNU sparse 1.0 entry offset %q: %w", offsetStr, err)
+		}
+		length, err := strconv.ParseInt(lengthStr, 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid GNU sparse 1.0 entry length %q: %w", lengthStr, err)
+		}
+		fragments = append(fragments, sparseFragment{Offset: offset, Size: length})
+	}
+
+	// The preamble is padded with NUL bytes up to the next block boundary.
+	consumed := pos - dataPos - int64(buf.Len())
+	return fragments, dataPos + roundUpBlock(consumed), nil
+}
+
+// parseOctal parses a NUL/space-padded octal numeric tar header field.
+func parseOctal(b []byte) (int64, error) {
+	s := strings.TrimRight(string(b), " \x00")
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 8, 64)
+}
+
+// roundUpBlock rounds n up to the next multiple of tarBlockSize.
+func roundUpBlock(n int64) int64 {
+	return (n + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+}
+
+// sparseReader is an io.ReadCloser that reconstructs the logical (expanded)
+// content of a GNU/PAX sparse tar entry, synthesizing zero bytes for holes
+// and reading data fragments from the underlying tar file on demand. It only
+// supports sequential forward reading, just like [PartialReader].
+type sparseReader struct {
+	f          *os.File
+	fragments  []sparseFragment
+	rawOffsets []int64
+	pos        int64
+	size       int64
+	next       int // index of the next fragment not yet fully consumed
+}
+
+var _ io.ReadCloser = (*sparseReader)(nil)
+
+// newSparseReader returns a sparseReader for the entry whose packed fragment
+// data starts at rawDataStart within f, with the given logical size and
+// fragment map. As with [NewPartialReader], it duplicates f's file
+// descriptor, so the caller must eventually call [sparseReader.Close].
+func newSparseReader(f *os.File, rawDataStart, size int64, fragments []sparseFragment) (*sparseReader, error) {
+	duplicatedfd, err := unix.Dup(int(f.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	nf := os.NewFile(uintptr(duplicatedfd),
+		fmt.Sprintf("%s[sparse@%d:%d]", f.Name(), rawDataStart, size))
+
+	rawOffsets := make([]int64, len(fragments))
+	raw := rawDataStart
+	for i, frag := range fragments {
+		rawOffsets[i] = raw
+		raw += frag.Size
+	}
+	return &sparseReader{f: nf, fragments: fragments, rawOffsets: rawOffsets, size: size}, nil
+}
+
+// Read synthesizes the logical content, reading fragments from the
+// underlying file and zero-filling holes in between.
+func (sr *sparseReader) Read(p []byte) (n int, err error) {
+	if sr.pos >= sr.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > sr.size-sr.pos {
+		p = p[:sr.size-sr.pos]
+	}
+
+	if sr.next >= len(sr.fragments) {
+		// Past the last fragment: the remainder of the file is a hole.
+		clear(p)
+		sr.pos += int64(len(p))
+		return len(p), nil
+	}
+
+	frag := sr.fragments[sr.next]
+	if sr.pos < frag.Offset {
+		// A hole precedes the next fragment.
+		if holeLen := frag.Offset - sr.pos; int64(len(p)) > holeLen {
+			p = p[:holeLen]
+		}
+		clear(p)
+		sr.pos += int64(len(p))
+		return len(p), nil
+	}
+
+	// Inside the fragment itself.
+	fragEnd := frag.Offset + frag.Size
+	if avail := fragEnd - sr.pos; int64(len(p)) > avail {
+		p = p[:avail]
+	}
+	rawPos := sr.rawOffsets[sr.next] + (sr.pos - frag.Offset)
+	n, err = sr.f.ReadAt(p, rawPos)
+	sr.pos += int64(n)
+	if sr.pos >= fragEnd {
+		sr.next++
+	}
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Close releases the sparseReader's underlying duplicated file descriptor.
+func (sr *sparseReader) Close() error {
+	return sr.f.Close()
+}
@@ -0,0 +1,269 @@
+// Copyright 2025 by Harald Albrecht
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gtar
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	. "github.com/thediveo/fdooze"
+	. "github.com/thediveo/success"
+)
+
+// rawSparseHeaderBlock hand-crafts an old-format (pre-PAX) GNU sparse tar
+// header block: the kind of header archive/tar itself can only read, not
+// write (sparse file writing isn't implemented by the standard library).
+func rawSparseHeaderBlock(name string, realsize int64, fragments []sparseFragment) [512]byte {
+	var blk [512]byte
+	copy(blk[0:100], name)
+	copy(blk[100:108], "0000644\x00")
+	copy(blk[108:116], "0000000\x00")
+	copy(blk[116:124], "0000000\x00")
+
+	// The main header's "size" field carries the packed (on-disk) size, not
+	// the logical size; the latter goes into the GNU "realsize" field below.
+	var packed int64
+	for _, frag := range fragments {
+		packed += frag.Size
+	}
+	copy(blk[124:136], fmt.Sprintf("%011o\x00", packed))
+	copy(blk[136:148], fmt.Sprintf("%011o\x00", 0))
+	blk[156] = tar.TypeGNUSparse
+	copy(blk[257:263], "ustar ")
+	copy(blk[263:265], " \x00")
+
+	for i, frag := range fragments {
+		if i >= 4 {
+			break // extension blocks are not exercised by this test helper
+		}
+		entry := blk[386+i*24 : 386+i*24+24]
+		copy(entry[0:12], fmt.Sprintf("%011o\x00", frag.Offset))
+		copy(entry[12:24], fmt.Sprintf("%011o\x00", frag.Size))
+	}
+	copy(blk[483:495], fmt.Sprintf("%011o\x00", realsize))
+
+	for i := 148; i < 156; i++ {
+		blk[i] = ' '
+	}
+	var sum int64
+	for _, b := range blk {
+		sum += int64(b)
+	}
+	copy(blk[148:154], fmt.Sprintf("%06o", sum))
+	blk[154] = 0
+	blk[155] = ' '
+	return blk
+}
+
+// paxRecord formats a single PAX extended header record using the standard
+// self-referential "<length> key=value\n" length-prefix encoding.
+func paxRecord(key, value string) string {
+	n := len(key) + len(value) + 3
+	for {
+		s := fmt.Sprintf("%d %s=%s\n", n, key, value)
+		if len(s) == n {
+			return s
+		}
+		n = len(s)
+	}
+}
+
+// rawUstarHeaderBlock hand-crafts a plain ustar tar header block for the
+// given typeflag and size. Like [rawSparseHeaderBlock], this bypasses
+// tar.Writer, which refuses to write TypeXHeader entries at all and, for
+// regular PAX headers, silently drops any "GNU.sparse.*" record (that
+// namespace is reserved for archive/tar's own, disabled, sparse-file writing
+// support), making it useless for building these test fixtures.
+func rawUstarHeaderBlock(name string, typeflag byte, size int64) [512]byte {
+	var blk [512]byte
+	copy(blk[0:100], name)
+	copy(blk[100:108], "0000644\x00")
+	copy(blk[108:116], "0000000\x00")
+	copy(blk[116:124], "0000000\x00")
+	copy(blk[124:136], fmt.Sprintf("%011o\x00", size))
+	copy(blk[136:148], fmt.Sprintf("%011o\x00", 0))
+	blk[156] = typeflag
+	copy(blk[257:263], "ustar\x00")
+	copy(blk[263:265], "00")
+
+	for i := 148; i < 156; i++ {
+		blk[i] = ' '
+	}
+	var sum int64
+	for _, b := range blk {
+		sum += int64(b)
+	}
+	copy(blk[148:154], fmt.Sprintf("%06o", sum))
+	blk[154] = 0
+	blk[155] = ' '
+	return blk
+}
+
+// writePaxXHeaderEntry writes a TypeXHeader entry carrying the given PAX
+// records (in order) as the meta-header for whatever regular entry follows
+// it in w.
+func writePaxXHeaderEntry(w io.Writer, records [][2]string) {
+	var body strings.Builder
+	for _, kv := range records {
+		body.WriteString(paxRecord(kv[0], kv[1]))
+	}
+	bodyBytes := []byte(body.String())
+	blk := rawUstarHeaderBlock("PaxHeaders.0/sparse", tar.TypeXHeader, int64(len(bodyBytes)))
+	Expect(w.Write(blk[:])).Error().NotTo(HaveOccurred())
+	Expect(w.Write(bodyBytes)).Error().NotTo(HaveOccurred())
+	if pad := roundUpBlock(int64(len(bodyBytes))) - int64(len(bodyBytes)); pad > 0 {
+		Expect(w.Write(make([]byte, pad))).Error().NotTo(HaveOccurred())
+	}
+}
+
+// writeSparseFragments writes the packed (non-hole) fragment data, padded
+// to the next tar block boundary.
+func writeSparseFragments(w io.Writer, data []byte, fragments []sparseFragment) {
+	var packed int64
+	for _, frag := range fragments {
+		Expect(w.Write(data[frag.Offset : frag.Offset+frag.Size])).Error().NotTo(HaveOccurred())
+		packed += frag.Size
+	}
+	if pad := roundUpBlock(packed) - packed; pad > 0 {
+		Expect(w.Write(make([]byte, pad))).Error().NotTo(HaveOccurred())
+	}
+}
+
+// sparseLogicalContent expands fragments (against the given realsize) into
+// the dense byte slice they represent, for comparison in assertions.
+func sparseLogicalContent(realsize int64, fragments []sparseFragment, data []byte) []byte {
+	out := make([]byte, realsize)
+	for _, frag := range fragments {
+		copy(out[frag.Offset:frag.Offset+frag.Size], data[frag.Offset:frag.Offset+frag.Size])
+	}
+	return out
+}
+
+var _ = Describe("GNU/PAX sparse files", func() {
+
+	// dense is the logical content a 30-byte sparse file would have if fully
+	// expanded; fragments carries just the two non-hole regions that are
+	// actually stored on disk.
+	dense := []byte("AAAA----------BBBB------------")
+	fragments := []sparseFragment{
+		{Offset: 0, Size: 4},
+		{Offset: 14, Size: 4},
+	}
+
+	BeforeEach(func() {
+		goodfds := Filedescriptors()
+		DeferCleanup(func() {
+			Eventually(Filedescriptors).Within(2 * time.Second).ProbeEvery(100 * time.Millisecond).
+				ShouldNot(HaveLeakedFds(goodfds))
+		})
+	})
+
+	It("reads an old GNU format sparse file", func() {
+		var buf bytes.Buffer
+		blk := rawSparseHeaderBlock("sparse-gnu", int64(len(dense)), fragments)
+		Expect(buf.Write(blk[:])).Error().NotTo(HaveOccurred())
+		writeSparseFragments(&buf, dense, fragments)
+
+		tf := Successful(os.CreateTemp("", "testing-sparse-gnu-*"))
+		defer tf.Close()
+		defer os.Remove(tf.Name())
+		Expect(io.Copy(tf, &buf)).Error().NotTo(HaveOccurred())
+
+		i := Successful(New(tf.Name()))
+		defer i.Close()
+
+		r := Successful(i.Open("sparse-gnu"))
+		defer r.Close()
+		Expect(io.ReadAll(r)).To(Equal(sparseLogicalContent(int64(len(dense)), fragments, dense)))
+	})
+
+	It("reads a PAX 0.1 format sparse file", func() {
+		var packed bytes.Buffer
+		for _, frag := range fragments {
+			Expect(packed.Write(dense[frag.Offset : frag.Offset+frag.Size])).Error().NotTo(HaveOccurred())
+		}
+
+		var buf bytes.Buffer
+		writePaxXHeaderEntry(&buf, [][2]string{
+			{"GNU.sparse.name", "sparse-pax01"},
+			{"GNU.sparse.realsize", fmt.Sprintf("%d", len(dense))},
+			{"GNU.sparse.numblocks", fmt.Sprintf("%d", len(fragments))},
+			{"GNU.sparse.map", "0,4,14,4"},
+		})
+		hblk := rawUstarHeaderBlock("sparse-pax01", tar.TypeReg, int64(packed.Len()))
+		Expect(buf.Write(hblk[:])).Error().NotTo(HaveOccurred())
+		Expect(buf.Write(packed.Bytes())).Error().NotTo(HaveOccurred())
+		if pad := roundUpBlock(int64(packed.Len())) - int64(packed.Len()); pad > 0 {
+			Expect(buf.Write(make([]byte, pad))).Error().NotTo(HaveOccurred())
+		}
+
+		tf := Successful(os.CreateTemp("", "testing-sparse-pax01-*"))
+		defer tf.Close()
+		defer os.Remove(tf.Name())
+		Expect(io.Copy(tf, &buf)).Error().NotTo(HaveOccurred())
+
+		i := Successful(New(tf.Name()))
+		defer i.Close()
+
+		r := Successful(i.Open("sparse-pax01"))
+		defer r.Close()
+		Expect(io.ReadAll(r)).To(Equal(sparseLogicalContent(int64(len(dense)), fragments, dense)))
+	})
+
+	It("reads a PAX 1.0 format sparse file", func() {
+		var content bytes.Buffer
+		Expect(content.WriteString(fmt.Sprintf("%d\n", len(fragments)))).Error().NotTo(HaveOccurred())
+		for _, frag := range fragments {
+			Expect(content.WriteString(fmt.Sprintf("%d\n%d\n", frag.Offset, frag.Size))).Error().NotTo(HaveOccurred())
+		}
+		if pad := roundUpBlock(int64(content.Len())) - int64(content.Len()); pad > 0 {
+			Expect(content.Write(make([]byte, pad))).Error().NotTo(HaveOccurred())
+		}
+		for _, frag := range fragments {
+			Expect(content.Write(dense[frag.Offset : frag.Offset+frag.Size])).Error().NotTo(HaveOccurred())
+		}
+
+		var buf bytes.Buffer
+		writePaxXHeaderEntry(&buf, [][2]string{
+			{"GNU.sparse.major", "1"},
+			{"GNU.sparse.minor", "0"},
+			{"GNU.sparse.name", "sparse-pax10"},
+			{"GNU.sparse.realsize", fmt.Sprintf("%d", len(dense))},
+		})
+		hblk := rawUstarHeaderBlock("sparse-pax10", tar.TypeReg, int64(content.Len()))
+		Expect(buf.Write(hblk[:])).Error().NotTo(HaveOccurred())
+		Expect(buf.Write(content.Bytes())).Error().NotTo(HaveOccurred())
+
+		tf := Successful(os.CreateTemp("", "testing-sparse-pax10-*"))
+		defer tf.Close()
+		defer os.Remove(tf.Name())
+		Expect(io.Copy(tf, &buf)).Error().NotTo(HaveOccurred())
+
+		i := Successful(New(tf.Name()))
+		defer i.Close()
+
+		r := Successful(i.Open("sparse-pax10"))
+		defer r.Close()
+		Expect(io.ReadAll(r)).To(Equal(sparseLogicalContent(int64(len(dense)), fragments, dense)))
+	})
+
+})